@@ -0,0 +1,122 @@
+package main
+
+import (
+	"math"
+	"strings"
+	"sync"
+	"time"
+)
+
+// clientIdleTimeout bounds how long a quiet client's state is kept around.
+const clientIdleTimeout = 10 * time.Minute
+
+// Baselines for the per-client QNAME features. Covert channels that pack
+// payload into subdomains push entropy toward log2(alphabet) and labels
+// toward the 63-byte limit; legitimate labels are much lower on both counts.
+//
+// QNAMEEntropyBaseline is tuned to this repo's actual covert channels
+// (covert-txt/covert-cname hex-encode their payload via hex.EncodeToString),
+// whose per-char entropy maxes out at log2(16)=4.0 bits/char. A baseline
+// anywhere near that ceiling would put hex-encoded exfil traffic inside the
+// +/-20% tolerance band alongside legitimate labels, so the entropy term
+// would never fire on the channels this repo implements.
+const (
+	QNAMEEntropyBaseline     = 3.0  // bits/char
+	QNAMELabelLengthBaseline = 30.0 // mean leftmost-label length, chars
+	QNAMELabelCountBaseline  = 3.0  // mean label count, e.g. "www.example.com"
+)
+
+// ClientState is the per-source-IP rolling window and scoring state that
+// used to be tracked globally. A single noisy client can no longer drown out
+// a low-and-slow sender on another IP, and a client is only ever scored
+// against its own history.
+type ClientState struct {
+	mu sync.Mutex
+
+	window          []DNSPacketRecord
+	suspicionScore  float64
+	droppedPackets  int
+	delayedPackets  int
+	totalPackets    int
+	currentPacketID int
+	lastSeen        time.Time
+}
+
+var (
+	clientStatesMutex sync.Mutex
+	clientStates      = make(map[string]*ClientState)
+)
+
+// getClientState returns the ClientState for srcIP, creating one if this is
+// the first packet seen from it.
+func getClientState(srcIP string) *ClientState {
+	clientStatesMutex.Lock()
+	defer clientStatesMutex.Unlock()
+
+	cs, exists := clientStates[srcIP]
+	if !exists {
+		cs = &ClientState{}
+		clientStates[srcIP] = cs
+	}
+	cs.lastSeen = time.Now()
+	return cs
+}
+
+// reapIdleClients evicts client state that's gone quiet, so a network full
+// of one-off clients doesn't grow the map forever.
+func reapIdleClients() {
+	ticker := time.NewTicker(clientIdleTimeout)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		clientStatesMutex.Lock()
+		for ip, cs := range clientStates {
+			cs.mu.Lock()
+			idle := time.Since(cs.lastSeen) > clientIdleTimeout
+			cs.mu.Unlock()
+			if idle {
+				delete(clientStates, ip)
+			}
+		}
+		clientStatesMutex.Unlock()
+	}
+}
+
+// shannonEntropy returns the Shannon entropy, in bits per character, of s.
+func shannonEntropy(s string) float64 {
+	if len(s) == 0 {
+		return 0
+	}
+
+	counts := make(map[rune]int)
+	for _, r := range s {
+		counts[r]++
+	}
+
+	n := float64(len(s))
+	entropy := 0.0
+	for _, count := range counts {
+		p := float64(count) / n
+		entropy -= p * math.Log2(p)
+	}
+	return entropy
+}
+
+// leftmostLabel returns the first (leftmost, most subdomain-specific) label
+// of a QNAME, which is where covert channels typically pack their payload.
+func leftmostLabel(qname string) string {
+	name := strings.TrimSuffix(qname, ".")
+	if idx := strings.IndexByte(name, '.'); idx != -1 {
+		return name[:idx]
+	}
+	return name
+}
+
+// labelCount returns the number of dot-separated labels in a QNAME.
+func labelCount(qname string) int {
+	name := strings.TrimSuffix(qname, ".")
+	if name == "" {
+		return 0
+	}
+	return strings.Count(name, ".") + 1
+}
@@ -0,0 +1,23 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"testing"
+)
+
+// TestEntropyTermFlagsHexCovertTraffic guards against QNAMEEntropyBaseline
+// drifting back up near log2(16)=4.0 bits/char, which would silently zero
+// out the entropy term for the hex-encoded channels covert-txt and
+// covert-cname actually use (see client_state.go).
+func TestEntropyTermFlagsHexCovertTraffic(t *testing.T) {
+	sum := sha256.Sum256([]byte("covert payload chunk"))
+	hexLabel := hex.EncodeToString(sum[:])[:63] // sized like a single covert-channel label
+
+	entropy := shannonEntropy(hexLabel)
+	score := squaredDeviationFromBaseline(entropy, QNAMEEntropyBaseline)
+
+	if score <= 0 {
+		t.Fatalf("hex-encoded label entropy %.2f bits/char scored %.2f against baseline %.2f; want a nonzero score so the entropy term actually flags hex exfil traffic", entropy, score, QNAMEEntropyBaseline)
+	}
+}
@@ -7,7 +7,6 @@ import (
 	"math"
 	"math/rand"
 	"os"
-	"sync"
 	"time"
 
 	"github.com/google/gopacket"
@@ -31,33 +30,79 @@ const (
 	DelayStrategy = "delay"
 )
 
+// Expected EDNS(0) metadata baselines for the rolling window, derived from
+// real-world resolver behavior rather than per-RR-type frequency.
+const (
+	EDNSPresenceBaseline = 60.0   // % of packets expected to carry an OPT record
+	EDNSDORatioBaseline  = 25.0   // % of OPT-bearing packets expected to set DO=1
+	EDNSUDPSizeBaseline  = 4096.0 // mean advertised UDP payload size (matches sec/sender.go's DefaultEDNSUDPSize)
+)
+
+// squaredDeviationFromBaseline mirrors calculateSuspicionScore's tolerance band
+// (+/-20%) for features that aren't keyed by DNS record type. It's used for
+// features on a percentage scale (0-100ish) or smaller - the EDNS ratios and
+// the QNAME entropy/length/label-count terms - alongside the type-frequency
+// terms they're summed with; a raw byte-scale feature needs
+// relativeDeviationScore instead so its squared deviation doesn't dwarf
+// every other term.
+func squaredDeviationFromBaseline(observed, baseline float64) float64 {
+	if observed <= baseline*1.2 && observed >= baseline*0.8 {
+		return 0.0
+	} else if observed < baseline*0.8 {
+		return math.Pow(baseline*0.8-observed, 2)
+	}
+	return math.Pow(observed-baseline*1.2, 2)
+}
+
+// relativeDeviationScore scores observed against baseline as a percentage of
+// baseline (so observed == baseline always scores 0, regardless of the
+// feature's natural unit), then applies the same +/-20% tolerance band as
+// squaredDeviationFromBaseline. Use this for byte/count-scale features -
+// like the mean EDNS UDP payload size - that would otherwise produce squared
+// deviations orders of magnitude larger than the percentage-scale terms they
+// get summed with.
+func relativeDeviationScore(observed, baseline float64) float64 {
+	if baseline == 0 {
+		return 0
+	}
+	return squaredDeviationFromBaseline(observed/baseline*100, 100)
+}
+
 // DNSRecordType holds information about DNS record types
 type DNSRecordType struct {
 	Name      string
 	Frequency float64 // Expected frequency score (0-100, higher = more common)
 }
 
+// EDNSInfo holds the fields recovered from a parsed OPT pseudo-record (RFC 6891).
+type EDNSInfo struct {
+	Present  bool   // OPT record was present in the Additionals section
+	UDPSize  uint16 // Requester's advertised UDP payload size (carried in the OPT CLASS field)
+	ExtRCode uint8  // Upper 8 bits of the extended RCODE (carried in the OPT TTL field)
+	Version  uint8  // EDNS version (carried in the OPT TTL field)
+	DO       bool   // DNSSEC OK bit (bit 15 of the OPT TTL field)
+}
+
 // DNSPacketRecord holds information about a single DNS packet for rolling window analysis
 type DNSPacketRecord struct {
-	PacketID int
-	DNSTypes []layers.DNSType // All DNS types observed in this packet (questions + answers)
+	PacketID     int
+	DNSTypes     []layers.DNSType // All DNS types observed in this packet (questions + answers)
+	EDNS         EDNSInfo         // EDNS(0)/DNSSEC context for this packet
+	QNAMEEntropy float64          // Shannon entropy (bits/char) of the leftmost QNAME label
+	QNAMELength  int              // Length of the leftmost QNAME label, chars
+	LabelCount   int              // Number of dot-separated labels in the QNAME
+	Truncated    bool             // Recovered from a TCP DNS message that hadn't fully arrived; DNSTypes may be an incomplete view of the real message
 }
 
+// maxWindowSize bounds each client's rolling window of packets.
+const maxWindowSize = 100
+
 // Global variables for DNS type frequency tracking
 var (
-	dnsTypeMutex    sync.RWMutex
-	totalDNSPackets int
-	suspicionScore  float64 // Running suspicion score
-	droppedPackets  int     // Counter for dropped packets
-	delayedPackets  int     // Counter for delayed packets
-
-	// Rolling window for last 100 packets
-	dnsPacketWindow []DNSPacketRecord
-	maxWindowSize   = 100
-	currentPacketID = 0
-
-	// DNS record frequency baseline (based on real-world usage data)
-	dnsFrequencyBaseline = map[layers.DNSType]DNSRecordType{
+	// DNS record frequency baseline for clients that do NOT set the EDNS(0) DO bit
+	// (based on real-world usage data). DNSSEC RR types are effectively zero here,
+	// since a non-DNSSEC-aware client has no reason to ever see them.
+	dnsFrequencyBaselineDO0 = map[layers.DNSType]DNSRecordType{
 		// Extremely High frequency
 		layers.DNSTypeA: {"A", 40},
 		// Very High frequency
@@ -70,13 +115,48 @@ var (
 		layers.DNSTypeMX:    {"MX", 8},
 		layers.DNSTypeCNAME: {"CNAME", 8},
 		layers.DNSTypeSOA:   {"SOA", 8},
-		// Moderate frequency
+		// Low frequency
+		257: {"CAA", 2},
+		35:  {"NAPTR", 2},
+		52:  {"TLSA", 2},
+		// Very Low frequency
+		44: {"SSHFP", 0.5},
+		39: {"DNAME", 0.5},
+		// Extremely Low frequency
+		29:  {"LOC", 0.2},
+		256: {"URI", 0.2},
+		// Effectively zero: DNSSEC RRs only make sense once DO=1 was requested
+		43:                {"DS", 0},
+		48:                {"DNSKEY", 0},
+		46:                {"RRSIG", 0},
+		layers.DNSTypeSRV: {"SRV", 5}, // not a DNSSEC RR; same frequency as in the DO=1 baseline
+		47:                {"NSEC", 0},
+		50:                {"NSEC3", 0},
+		// Effectively Zero frequency
+		13: {"HINFO", 0},
+		17: {"RP", 0},
+	}
+
+	// DNS record frequency baseline for clients that DID set the EDNS(0) DO bit.
+	// DNSSEC RRs are expected here, at roughly the rate a DNSSEC-validating
+	// resolver would pull them alongside the records it's actually asking for.
+	dnsFrequencyBaselineDO1 = map[layers.DNSType]DNSRecordType{
+		layers.DNSTypeA:     {"A", 35},
+		layers.DNSTypeAAAA:  {"AAAA", 18},
+		layers.DNSTypeNS:    {"NS", 6},
+		layers.DNSTypePTR:   {"PTR", 6},
+		65:                  {"HTTPS", 6},
+		layers.DNSTypeTXT:   {"TXT", 6},
+		layers.DNSTypeMX:    {"MX", 6},
+		layers.DNSTypeCNAME: {"CNAME", 6},
+		layers.DNSTypeSOA:   {"SOA", 6},
+		// Moderate frequency - expected once a client is DNSSEC-aware
 		43:                {"DS", 5},
 		48:                {"DNSKEY", 5},
-		46:                {"RRSIG", 5},
+		46:                {"RRSIG", 8},
 		layers.DNSTypeSRV: {"SRV", 5},
-		47:                {"NSEC", 5},
-		50:                {"NSEC3", 5},
+		47:                {"NSEC", 3},
+		50:                {"NSEC3", 3},
 		// Low frequency
 		257: {"CAA", 2},
 		35:  {"NAPTR", 2},
@@ -106,17 +186,26 @@ func logOutput(format string, args ...interface{}) {
 	}
 }
 
+// Function to get the frequency baseline matching the client's DNSSEC awareness (DO bit)
+func baselineFor(do bool) map[layers.DNSType]DNSRecordType {
+	if do {
+		return dnsFrequencyBaselineDO1
+	}
+	return dnsFrequencyBaselineDO0
+}
+
 // Function to get DNS type name and info for better readability
-func getDNSTypeInfo(dnsType layers.DNSType) (string, float64) {
-	if info, exists := dnsFrequencyBaseline[dnsType]; exists {
+func getDNSTypeInfo(dnsType layers.DNSType, do bool) (string, float64) {
+	if info, exists := baselineFor(do)[dnsType]; exists {
 		return info.Name, info.Frequency
 	}
 	return fmt.Sprintf("Unknown_%d", int(dnsType)), 0
 }
 
-// Function to calculate suspicion score based on DNS type frequency deviation
-func calculateSuspicionScore(dnsType layers.DNSType, observedPercentage float64) float64 {
-	_, expectedFreq := getDNSTypeInfo(dnsType)
+// Function to calculate suspicion score based on DNS type frequency deviation.
+// do selects which baseline (DNSSEC-aware or not) the observed percentage is scored against.
+func calculateSuspicionScore(dnsType layers.DNSType, observedPercentage float64, do bool) float64 {
+	_, expectedFreq := getDNSTypeInfo(dnsType, do)
 
 	// Convert expected frequency to percentage (baseline)
 	expectedPercentage := float64(expectedFreq)
@@ -133,12 +222,36 @@ func calculateSuspicionScore(dnsType layers.DNSType, observedPercentage float64)
 	return 0.0 // Default case, should not happen
 }
 
-// Function to analyze DNS packet and detect potential covert channel
-func analyzeDNSPacket(dns *layers.DNS) {
-	dnsTypeMutex.Lock()
-	defer dnsTypeMutex.Unlock()
+// parseEDNS looks for an OPT pseudo-record (type 41) among the Additionals and
+// decodes the UDP payload size, extended RCODE, version, and DO bit per RFC 6891 §6.1.3.
+func parseEDNS(dns *layers.DNS) EDNSInfo {
+	for _, rr := range dns.Additionals {
+		if rr.Type != layers.DNSTypeOPT {
+			continue
+		}
+		return EDNSInfo{
+			Present:  true,
+			UDPSize:  uint16(rr.Class),
+			ExtRCode: uint8(rr.TTL >> 24),
+			Version:  uint8(rr.TTL >> 16),
+			DO:       rr.TTL&0x8000 != 0,
+		}
+	}
+	return EDNSInfo{}
+}
+
+// Function to analyze DNS packet and detect potential covert channel. srcIP
+// scopes the rolling window to the client that sent it, so one noisy client
+// can't drown out a low-and-slow sender on another IP. truncated marks a
+// message recovered from a TCP DNS flow that hadn't fully arrived yet, so
+// its DNSTypes reflect only the records decoded so far, not the full message.
+func analyzeDNSPacket(dns *layers.DNS, srcIP string, truncated bool) {
+	cs := getClientState(srcIP)
+
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
 
-	totalDNSPackets++
+	cs.totalPackets++
 
 	// Collect DNS types from this packet for rolling window
 	var packetDNSTypes []layers.DNSType
@@ -149,98 +262,156 @@ func analyzeDNSPacket(dns *layers.DNS) {
 		packetDNSTypes = append(packetDNSTypes, answer.Type)
 	}
 
+	edns := parseEDNS(dns)
+
+	record := DNSPacketRecord{
+		PacketID:  cs.currentPacketID,
+		DNSTypes:  packetDNSTypes,
+		EDNS:      edns,
+		Truncated: truncated,
+	}
+	if len(dns.Questions) > 0 {
+		qname := string(dns.Questions[0].Name)
+		record.QNAMEEntropy = shannonEntropy(leftmostLabel(qname))
+		record.QNAMELength = len(leftmostLabel(qname))
+		record.LabelCount = labelCount(qname)
+	}
+
 	// Add to rolling window
-	dnsPacketWindow = append(dnsPacketWindow, DNSPacketRecord{
-		PacketID: currentPacketID,
-		DNSTypes: packetDNSTypes,
-	})
-	currentPacketID++
+	cs.window = append(cs.window, record)
+	cs.currentPacketID++
 
 	// Maintain window size
-	if len(dnsPacketWindow) > maxWindowSize {
-		dnsPacketWindow = dnsPacketWindow[1:]
+	if len(cs.window) > maxWindowSize {
+		cs.window = cs.window[1:]
 	}
 
-	evaluateThreatLevel()
+	evaluateThreatLevel(cs, srcIP)
 }
 
 // Function to print DNS type frequency analysis with suspicion scoring based on rolling window
-func evaluateThreatLevel() {
-	// Calculate frequency distribution based on rolling window
-	windowTypeFrequency := make(map[layers.DNSType]int)
-	totalWindowTypes := 0
-
-	// Count DNS types in the current window
-	for _, packetRecord := range dnsPacketWindow {
-		for _, dnsType := range packetRecord.DNSTypes {
-			windowTypeFrequency[dnsType]++
-			totalWindowTypes++
+func evaluateThreatLevel(cs *ClientState, srcIP string) {
+	// Split the window by the DO bit so DNSSEC RRs are only scored as
+	// suspicious when they show up in a stream that never asked for them.
+	windowTypeFrequency := map[bool]map[layers.DNSType]int{false: {}, true: {}}
+	totalWindowTypes := map[bool]int{false: 0, true: 0}
+
+	var optCount, doCount int
+	var udpSizeSum int
+	var entropySum, lengthSum, labelCountSum float64
+
+	for _, packetRecord := range cs.window {
+		do := packetRecord.EDNS.DO
+		// A truncated record's DNSTypes is only whatever got decoded before
+		// the message ran out of buffered bytes, not the full set of types
+		// the real message carries - counting it would skew the frequency
+		// distribution toward however far reassembly happened to get.
+		if !packetRecord.Truncated {
+			for _, dnsType := range packetRecord.DNSTypes {
+				windowTypeFrequency[do][dnsType]++
+				totalWindowTypes[do]++
+			}
+		}
+		if packetRecord.EDNS.Present {
+			optCount++
+			udpSizeSum += int(packetRecord.EDNS.UDPSize)
+			if do {
+				doCount++
+			}
 		}
+		entropySum += packetRecord.QNAMEEntropy
+		lengthSum += float64(packetRecord.QNAMELength)
+		labelCountSum += float64(packetRecord.LabelCount)
 	}
 
-	// Calculate new suspicion score based on current window only
-	windowSuspicionScore := 0.0
-
-	if totalWindowTypes == 0 {
+	windowSize := len(cs.window)
+	if windowSize == 0 {
 		return
 	}
 
-	for dnsType, count := range windowTypeFrequency {
-		percentage := float64(count) / float64(totalWindowTypes) * 100
+	windowSuspicionScore := 0.0
 
-		// name, expectedFreq := getDNSTypeInfo(dnsType)
-		// logOutput("Type %s (%d): Count=%d, Percentage=%.2f%%, Expected=~%d%%\n",
-		// 	name, int(dnsType), count, percentage, expectedFreq)
+	for _, do := range []bool{false, true} {
+		if totalWindowTypes[do] == 0 {
+			continue
+		}
+		for dnsType, count := range windowTypeFrequency[do] {
+			percentage := float64(count) / float64(totalWindowTypes[do]) * 100
+			windowSuspicionScore += calculateSuspicionScore(dnsType, percentage, do)
+		}
+	}
 
-		windowSuspicionScore += calculateSuspicionScore(dnsType, percentage)
+	// EDNS metadata features: OPT presence ratio, DO ratio among OPT-bearing
+	// packets, and mean advertised UDP payload size. Covert channels rarely
+	// bother forging plausible EDNS metadata, so large deviations are telling.
+	optRatio := float64(optCount) / float64(windowSize) * 100
+	windowSuspicionScore += squaredDeviationFromBaseline(optRatio, EDNSPresenceBaseline)
+	if optCount > 0 {
+		doRatio := float64(doCount) / float64(optCount) * 100
+		windowSuspicionScore += squaredDeviationFromBaseline(doRatio, EDNSDORatioBaseline)
+		meanUDPSize := float64(udpSizeSum) / float64(optCount)
+		windowSuspicionScore += relativeDeviationScore(meanUDPSize, EDNSUDPSizeBaseline)
 	}
 
-	// Replace global suspicion score with window-based score
-	suspicionScore = windowSuspicionScore
+	// QNAME shape features: entropy and length of the leftmost label, and
+	// the QNAME's label count.
+	// Data-exfil labels cluster near the 63-byte limit and push entropy
+	// toward log2(alphabet); legitimate labels don't. Covert channels also
+	// tend to split payload across more labels than a typical hostname uses.
+	meanEntropy := entropySum / float64(windowSize)
+	meanLength := lengthSum / float64(windowSize)
+	meanLabelCount := labelCountSum / float64(windowSize)
+	windowSuspicionScore += squaredDeviationFromBaseline(meanEntropy, QNAMEEntropyBaseline)
+	windowSuspicionScore += squaredDeviationFromBaseline(meanLength, QNAMELabelLengthBaseline)
+	windowSuspicionScore += squaredDeviationFromBaseline(meanLabelCount, QNAMELabelCountBaseline)
+
+	// Replace the client's suspicion score with the window-based score
+	cs.suspicionScore = windowSuspicionScore
 
 	// Evaluate overall threat level
-	logThreatLevelAnalysis()
+	logThreatLevelAnalysis(cs, srcIP)
 }
 
 // Function to evaluate the overall threat level based on suspicion score
-func logThreatLevelAnalysis() {
-	windowSize := len(dnsPacketWindow)
-	logOutput("\n=== DNS Threat Level Assessment ===\n")
-	logOutput("Total DNS packets processed: %d\n", totalDNSPackets)
-	logOutput("Current window size: %d packets (max %d)\n", windowSize, maxWindowSize)
-
-	if suspicionScore >= CriticalThreatThreshold {
-		logOutput("🚨 CRITICAL THREAT LEVEL (Score: %.1f)\n", suspicionScore)
-	} else if suspicionScore >= HighThreatThreshold {
-		logOutput("⚠️  HIGH THREAT LEVEL (Score: %.1f)\n", suspicionScore)
-	} else if suspicionScore >= MediumThreatThreshold {
-		logOutput("🟡 MEDIUM THREAT LEVEL (Score: %.1f)\n", suspicionScore)
-	} else if suspicionScore >= LowThreatThreshold {
-		logOutput("🟢 LOW THREAT LEVEL (Score: %.1f)\n", suspicionScore)
+func logThreatLevelAnalysis(cs *ClientState, srcIP string) {
+	logOutput("\n=== DNS Threat Level Assessment (client %s) ===\n", srcIP)
+	logOutput("Total DNS packets processed for client: %d\n", cs.totalPackets)
+	logOutput("Current window size: %d packets (max %d)\n", len(cs.window), maxWindowSize)
+
+	score := cs.suspicionScore
+	if score >= CriticalThreatThreshold {
+		logOutput("🚨 CRITICAL THREAT LEVEL (Score: %.1f)\n", score)
+	} else if score >= HighThreatThreshold {
+		logOutput("⚠️  HIGH THREAT LEVEL (Score: %.1f)\n", score)
+	} else if score >= MediumThreatThreshold {
+		logOutput("🟡 MEDIUM THREAT LEVEL (Score: %.1f)\n", score)
+	} else if score >= LowThreatThreshold {
+		logOutput("🟢 LOW THREAT LEVEL (Score: %.1f)\n", score)
 	} else {
-		logOutput("✅ NORMAL ACTIVITY (Score: %.1f)\n", suspicionScore)
+		logOutput("✅ NORMAL ACTIVITY (Score: %.1f)\n", score)
 	}
 }
 
-// Function to mitigate packets based on strategy
-func mitigatePacket(strategy string, suspicionScore float64) bool {
+// Function to mitigate packets based on strategy, against the offending
+// client's own score and counters rather than a global aggregate.
+func mitigatePacket(cs *ClientState, strategy string, suspicionScore float64) bool {
 	// Apply mitigation based on strategy with 1/10th probability
 	if rand.Float32() < MitigationProbability {
 		switch strategy {
 		case DropStrategy:
-			dnsTypeMutex.Lock()
-			droppedPackets++
-			totalDropped := droppedPackets
-			dnsTypeMutex.Unlock()
+			cs.mu.Lock()
+			cs.droppedPackets++
+			totalDropped := cs.droppedPackets
+			cs.mu.Unlock()
 
 			logOutput("🚫 PACKET DROPPED (Strategy: %s, Suspicion Score: %.1f, Total Dropped: %d)\n",
 				strategy, suspicionScore, totalDropped)
 			return true // Packet was mitigated (droppe
 		case DelayStrategy:
-			dnsTypeMutex.Lock()
-			delayedPackets++
-			totalDelayed := delayedPackets
-			dnsTypeMutex.Unlock()
+			cs.mu.Lock()
+			cs.delayedPackets++
+			totalDelayed := cs.delayedPackets
+			cs.mu.Unlock()
 
 			logOutput("⏳ PACKET DELAYED (Strategy: %s, Suspicion Score: %.1f, Delay: %dms, Total Delayed: %d)\n",
 				strategy, suspicionScore, DelayDuration, totalDelayed)
@@ -278,10 +449,25 @@ func processEthernetPacket(nc *nats.Conn, iface string, data []byte) {
 		logOutput("%s\n", gopacket.LayerDump(ipLayer))
 	}
 
-	// Check for TCP layer
+	srcIP, _ := srcIPFor(packet)
+
+	// Check for TCP layer. A covert channel (or a legitimate AXFR/DNSSEC/long
+	// TXT response) that switches to TCP/53 would otherwise pass through
+	// unclassified, since only the UDP path fed packets to analyzeDNSPacket.
+	completedAtSegmentEnd := true
+	isTCPDNS := false
 	if tcpLayer := packet.Layer(layers.LayerTypeTCP); tcpLayer != nil {
-		logOutput("TCP layer detected.\n")
-		logOutput("%s\n", gopacket.LayerDump(tcpLayer))
+		tcp, _ := tcpLayer.(*layers.TCP)
+		if tcp.SrcPort == 53 || tcp.DstPort == 53 {
+			isTCPDNS = true
+			if key, ok := tcpFlowKeyFor(packet, tcp); ok {
+				var messages []tcpDNSMessage
+				messages, completedAtSegmentEnd = processTCPDNSSegment(key, tcp.Payload)
+				for _, msg := range messages {
+					analyzeDNSPacket(msg.DNS, srcIP, msg.Truncated)
+				}
+			}
+		}
 	}
 
 	// Check for UDP layer
@@ -296,7 +482,7 @@ func processEthernetPacket(nc *nats.Conn, iface string, data []byte) {
 			if dnsLayer := packet.Layer(layers.LayerTypeDNS); dnsLayer != nil {
 				// logOutput("DNS layer detected in UDP packet.\n")
 				dns, _ := dnsLayer.(*layers.DNS)
-				analyzeDNSPacket(dns)
+				analyzeDNSPacket(dns, srcIP, false)
 			}
 		}
 	}
@@ -308,9 +494,18 @@ func processEthernetPacket(nc *nats.Conn, iface string, data []byte) {
 		subject = "outpktsec"
 	}
 
-	logOutput("Suspicion Score: %.1f\n", suspicionScore)
+	cs := getClientState(srcIP)
+	cs.mu.Lock()
+	clientScore := cs.suspicionScore
+	cs.mu.Unlock()
+
+	logOutput("Suspicion Score for client %s: %.1f\n", srcIP, clientScore)
 
-	if suspicionScore > CriticalThreatThreshold && mitigatePacket(DropStrategy, suspicionScore) {
+	// For TCP, only drop at a DNS message boundary: dropping a segment that's
+	// only a partial frame would desync the stream for every message after
+	// it, not just the suspect one.
+	canMitigate := !isTCPDNS || completedAtSegmentEnd
+	if canMitigate && clientScore > CriticalThreatThreshold && mitigatePacket(cs, DropStrategy, clientScore) {
 		return // Packet was dropped, don't publish it
 	}
 
@@ -320,6 +515,44 @@ func processEthernetPacket(nc *nats.Conn, iface string, data []byte) {
 	}
 }
 
+// srcIPFor extracts the packet's source IP (v4 or v6) as a string, used to
+// key per-client rolling-window state.
+func srcIPFor(packet gopacket.Packet) (string, bool) {
+	if ipv4Layer := packet.Layer(layers.LayerTypeIPv4); ipv4Layer != nil {
+		ipv4, _ := ipv4Layer.(*layers.IPv4)
+		return ipv4.SrcIP.String(), true
+	}
+	if ipv6Layer := packet.Layer(layers.LayerTypeIPv6); ipv6Layer != nil {
+		ipv6, _ := ipv6Layer.(*layers.IPv6)
+		return ipv6.SrcIP.String(), true
+	}
+	return "", false
+}
+
+// tcpFlowKeyFor builds the 4-tuple flow key for a TCP DNS segment from
+// whichever IP layer (v4 or v6) is present.
+func tcpFlowKeyFor(packet gopacket.Packet, tcp *layers.TCP) (tcpFlowKey, bool) {
+	if ipv4Layer := packet.Layer(layers.LayerTypeIPv4); ipv4Layer != nil {
+		ipv4, _ := ipv4Layer.(*layers.IPv4)
+		return tcpFlowKey{
+			SrcIP:   ipv4.SrcIP.String(),
+			SrcPort: uint16(tcp.SrcPort),
+			DstIP:   ipv4.DstIP.String(),
+			DstPort: uint16(tcp.DstPort),
+		}, true
+	}
+	if ipv6Layer := packet.Layer(layers.LayerTypeIPv6); ipv6Layer != nil {
+		ipv6, _ := ipv6Layer.(*layers.IPv6)
+		return tcpFlowKey{
+			SrcIP:   ipv6.SrcIP.String(),
+			SrcPort: uint16(tcp.SrcPort),
+			DstIP:   ipv6.DstIP.String(),
+			DstPort: uint16(tcp.DstPort),
+		}, true
+	}
+	return tcpFlowKey{}, false
+}
+
 func main() {
 	// Create log file with timestamp
 	timestamp := time.Now().Format("2006-01-02_15-04-05")
@@ -357,6 +590,12 @@ func main() {
 	logOutput("DNS Covert Channel Detection System Started")
 	logOutput("Using advanced frequency-based threat scoring system")
 
+	// Bound the TCP DNS flow table by evicting idle flows
+	go reapIdleTCPFlows()
+
+	// Bound the per-client scoring state by evicting clients gone quiet
+	go reapIdleClients()
+
 	// Simple Publisher
 	//nc.Publish("foo", []byte("Hello World"))
 
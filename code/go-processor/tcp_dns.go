@@ -0,0 +1,221 @@
+package main
+
+import (
+	"encoding/binary"
+	"sync"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+// TCP DNS messages are framed with a 2-byte length prefix ahead of each
+// message (RFC 1035 §4.2.2), and a single segment can carry zero, one, or
+// several such messages, or only part of one. We buffer per-flow until each
+// length-delimited message is complete.
+const tcpFlowIdleTimeout = 30 * time.Second
+
+// tcpFlowKey identifies a TCP DNS flow by its 4-tuple.
+type tcpFlowKey struct {
+	SrcIP   string
+	SrcPort uint16
+	DstIP   string
+	DstPort uint16
+}
+
+// tcpFlowState buffers the bytes received so far for a single TCP DNS flow.
+type tcpFlowState struct {
+	buffer   []byte
+	lastSeen time.Time
+	// partialAnalyzed marks that the message currently at the front of
+	// buffer has already contributed a best-effort partial parse, so later
+	// segments that only add more of the same still-incomplete message
+	// don't feed duplicate records into the analyzer's rolling window.
+	partialAnalyzed bool
+}
+
+var (
+	tcpFlowMutex sync.Mutex
+	tcpFlows     = make(map[tcpFlowKey]*tcpFlowState)
+)
+
+// tcpDNSMessage is a single length-delimited DNS message recovered from a TCP
+// flow, along with whether it had to be partially decoded.
+type tcpDNSMessage struct {
+	DNS       *layers.DNS
+	Truncated bool
+}
+
+// processTCPDNSSegment appends newly received payload bytes to the flow's
+// buffer and pulls out every complete length-delimited DNS message it can.
+// It reports whether the last message it extracted completed exactly at the
+// end of this segment, so the caller can tell mitigation decisions apart
+// from segments that only contribute to a still-buffering message.
+func processTCPDNSSegment(key tcpFlowKey, payload []byte) (messages []tcpDNSMessage, completedAtSegmentEnd bool) {
+	if len(payload) == 0 {
+		return nil, false
+	}
+
+	tcpFlowMutex.Lock()
+	flow, exists := tcpFlows[key]
+	if !exists {
+		flow = &tcpFlowState{}
+		tcpFlows[key] = flow
+	}
+	flow.buffer = append(flow.buffer, payload...)
+	flow.lastSeen = time.Now()
+	buffer := flow.buffer
+	tcpFlowMutex.Unlock()
+
+	consumed := 0
+	for {
+		remaining := buffer[consumed:]
+		if len(remaining) < 2 {
+			break
+		}
+
+		msgLen := int(binary.BigEndian.Uint16(remaining[:2]))
+		if len(remaining)-2 < msgLen {
+			// Not enough bytes yet for a clean decode. Best-effort partial
+			// parse of what we do have, so a covert channel can't hide data
+			// in a message it never intends to let us finish reassembling.
+			// Only do this once per in-progress message: every later
+			// segment re-parses the same still-growing buffer, and feeding
+			// each of those to the analyzer would count one message's
+			// records several times over in the rolling window.
+			tcpFlowMutex.Lock()
+			alreadyAnalyzed := flow.partialAnalyzed
+			flow.partialAnalyzed = true
+			tcpFlowMutex.Unlock()
+
+			if !alreadyAnalyzed {
+				if dns, ok := tryPartialParseDNS(remaining[2:]); ok {
+					messages = append(messages, tcpDNSMessage{DNS: dns, Truncated: true})
+				}
+			}
+			break
+		}
+
+		msgBytes := remaining[2 : 2+msgLen]
+		dns := &layers.DNS{}
+		if err := dns.DecodeFromBytes(msgBytes, gopacket.NilDecodeFeedback); err == nil {
+			messages = append(messages, tcpDNSMessage{DNS: dns})
+		}
+		consumed += 2 + msgLen
+		completedAtSegmentEnd = consumed == len(buffer)
+
+		tcpFlowMutex.Lock()
+		flow.partialAnalyzed = false
+		tcpFlowMutex.Unlock()
+	}
+
+	tcpFlowMutex.Lock()
+	flow.buffer = buffer[consumed:]
+	tcpFlowMutex.Unlock()
+
+	return messages, completedAtSegmentEnd
+}
+
+// tryPartialParseDNS decodes as much of a truncated DNS message as it can:
+// the 12-byte header plus every complete Question and Answer RR that fits,
+// stopping at the first one that doesn't. This mirrors the "decode what you
+// have" approach used for fragmented captures rather than discarding the
+// message outright.
+func tryPartialParseDNS(buf []byte) (*layers.DNS, bool) {
+	if len(buf) < 12 {
+		return nil, false
+	}
+
+	flags := binary.BigEndian.Uint16(buf[2:4])
+	dns := &layers.DNS{
+		ID:           binary.BigEndian.Uint16(buf[0:2]),
+		QR:           flags&0x8000 != 0,
+		OpCode:       layers.DNSOpCode((flags >> 11) & 0xF),
+		AA:           flags&0x0400 != 0,
+		TC:           flags&0x0200 != 0,
+		RD:           flags&0x0100 != 0,
+		RA:           flags&0x0080 != 0,
+		ResponseCode: layers.DNSResponseCode(flags & 0xF),
+		QDCount:      binary.BigEndian.Uint16(buf[4:6]),
+		ANCount:      binary.BigEndian.Uint16(buf[6:8]),
+		NSCount:      binary.BigEndian.Uint16(buf[8:10]),
+		ARCount:      binary.BigEndian.Uint16(buf[10:12]),
+	}
+
+	offset := 12
+	for i := 0; i < int(dns.QDCount); i++ {
+		name, next, ok := decodeDNSName(buf, offset)
+		if !ok || next+4 > len(buf) {
+			return dns, true
+		}
+		dns.Questions = append(dns.Questions, layers.DNSQuestion{
+			Name:  name,
+			Type:  layers.DNSType(binary.BigEndian.Uint16(buf[next : next+2])),
+			Class: layers.DNSClass(binary.BigEndian.Uint16(buf[next+2 : next+4])),
+		})
+		offset = next + 4
+	}
+
+	for i := 0; i < int(dns.ANCount); i++ {
+		name, next, ok := decodeDNSName(buf, offset)
+		if !ok || next+10 > len(buf) {
+			return dns, true
+		}
+		rdlen := int(binary.BigEndian.Uint16(buf[next+8 : next+10]))
+		rdataStart := next + 10
+		if rdataStart+rdlen > len(buf) {
+			return dns, true
+		}
+		dns.Answers = append(dns.Answers, layers.DNSResourceRecord{
+			Name:  name,
+			Type:  layers.DNSType(binary.BigEndian.Uint16(buf[next : next+2])),
+			Class: layers.DNSClass(binary.BigEndian.Uint16(buf[next+2 : next+4])),
+			TTL:   binary.BigEndian.Uint32(buf[next+4 : next+8]),
+			Data:  buf[rdataStart : rdataStart+rdlen],
+		})
+		offset = rdataStart + rdlen
+	}
+
+	return dns, offset < len(buf)
+}
+
+// decodeDNSName reads an uncompressed sequence of length-prefixed labels
+// starting at offset. Compression pointers are not followed: a name that
+// uses one is reported as not decodable (ok is false), which ends the
+// partial parse at that record.
+func decodeDNSName(buf []byte, offset int) (name []byte, next int, ok bool) {
+	start := offset
+	for {
+		if offset >= len(buf) {
+			return nil, 0, false
+		}
+		labelLen := int(buf[offset])
+		if labelLen == 0 {
+			offset++
+			break
+		}
+		if labelLen&0xC0 != 0 || offset+1+labelLen > len(buf) {
+			return nil, 0, false
+		}
+		offset += 1 + labelLen
+	}
+	return buf[start:offset], offset, true
+}
+
+// reapIdleTCPFlows periodically drops TCP DNS flow state that's gone quiet,
+// so a client that opens a connection and never completes a message doesn't
+// grow the buffer map forever.
+func reapIdleTCPFlows() {
+	ticker := time.NewTicker(tcpFlowIdleTimeout)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		tcpFlowMutex.Lock()
+		for key, flow := range tcpFlows {
+			if time.Since(flow.lastSeen) > tcpFlowIdleTimeout {
+				delete(tcpFlows, key)
+			}
+		}
+		tcpFlowMutex.Unlock()
+	}
+}
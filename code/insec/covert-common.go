@@ -2,6 +2,7 @@ package main
 
 import (
 	"bytes"
+	"encoding/binary"
 	"encoding/hex"
 	"fmt"
 	"os"
@@ -218,45 +219,45 @@ func handleEndSignal(sequenceNumber int) {
 	lastSequenceNumber = sequenceNumber
 }
 
-func getCovertDNSRequestHandler(questionHandler func(q dns.Question)) func(w dns.ResponseWriter, r *dns.Msg) {
-	return func(w dns.ResponseWriter, r *dns.Msg) {
-		fmt.Print("Received DNS request...\n")
-		m := new(dns.Msg)
-		m.SetReply(r)      // Prepare a basic reply structure
-		m.Compress = false // Disable compression for simplicity/compatibility
-
-		mapMutex.Lock()
-		if startTime == (time.Time{}) {
-			// Initialize start time if not already set
-			fmt.Printf("Start time not set, initializing...\n")
-			startTime = time.Now()
-		}
-		mapMutex.Unlock()
-
-		// We only care about standard queries
-		if r.Opcode != dns.OpcodeQuery {
-			w.WriteMsg(m) // Send empty reply for non-queries
-			return
-		}
-
-		// Process each question in the query (usually just one)
-		for _, q := range r.Question {
-			fmt.Printf("Received query: Name=[%s], Type=[%s]\n", q.Name, dns.TypeToString[q.Qtype])
-
-			// Check if this is normal traffic that should be ignored
-			if strings.Contains(q.Name, NORMAL_TRAFFIC_DOMAIN) {
-				fmt.Printf("Ignoring normal traffic query to: %s\n", q.Name)
-				continue // Skip processing this query
-			}
-
-			questionHandler(q) // Handle the question
-		} // End loop through questions
+// markCovertStartTime records when the first query that isn't normal
+// traffic arrives, so writeStatsToFile can report reassembly time. It is set
+// once and is not reset between messages.
+func markCovertStartTime() {
+	mapMutex.Lock()
+	defer mapMutex.Unlock()
+	if startTime == (time.Time{}) {
+		startTime = time.Now()
+	}
+}
 
-		// Send a response back to the client.
-		err := w.WriteMsg(m)
-		if err != nil {
-			// Log error if writing the response fails
-			fmt.Printf("Error writing DNS response: %v\n", err)
+// CovertOptionCode is the EDNS(0) option code, from the private-use range
+// RFC 6891 §6.1.2 reserves for local/experimental use, carrying a covert
+// channel's sequence number and end-of-message flag in the OPT pseudo-RR
+// instead of the sender folding them into the query name.
+const CovertOptionCode = 65001
+
+// decodeCovertOption extracts the sequence number and end-of-message flag a
+// sender packed into a CovertOptionCode EDNS(0) option: a big-endian uint32
+// sequence number followed by a single end-of-message flag byte. ok is false
+// if opt is nil or carries no such option, meaning the query isn't one of
+// ours.
+func decodeCovertOption(opt *dns.OPT) (sequenceNumber int, end bool, ok bool) {
+	if opt == nil {
+		return 0, false, false
+	}
+	for _, o := range opt.Option {
+		local, isLocal := o.(*dns.EDNS0_LOCAL)
+		if !isLocal || local.Code != CovertOptionCode || len(local.Data) != 5 {
+			continue
 		}
+		return int(binary.BigEndian.Uint32(local.Data[:4])), local.Data[4] != 0, true
 	}
+	return 0, false, false
+}
+
+// isNormalTrafficQuery reports whether a query belongs to the generated
+// "normal" background traffic rather than a covert channel, so extractors
+// and the resolver can skip it without forwarding it to an extractor.
+func isNormalTrafficQuery(name string) bool {
+	return strings.Contains(name, NORMAL_TRAFFIC_DOMAIN)
 }
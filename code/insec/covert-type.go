@@ -53,6 +53,17 @@ func combineReceivedChunks() {
 	currentSequenceNumber = 0
 }
 
+// typeExtractor recognizes the DNS-type-bit covert channel: every question's
+// Qtype carries 2 bits of payload, with a CNAME query marking the end of a
+// message. Unlike the TXT extractor it claims every query it sees, since a
+// sender using this channel never issues questions it doesn't mean to carry data in.
+type typeExtractor struct{}
+
+func (typeExtractor) Extract(q dns.Question, opt *dns.OPT) bool {
+	handleTypedDNSQuestion(q)
+	return true
+}
+
 func handleTypedDNSQuestion(q dns.Question) {
 	if q.Qtype == dns.TypeCNAME {
 		combineReceivedChunks()
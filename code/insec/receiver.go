@@ -4,6 +4,8 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"strings"
+	"time"
 
 	"github.com/miekg/dns"
 )
@@ -11,48 +13,112 @@ import (
 const LISTEN_ADDRESS = ":53"
 const BASE_DOMAIN = "example.com"
 
-func handleDNSRequest(w dns.ResponseWriter, r *dns.Msg) {
-	m := new(dns.Msg)
-	m.SetReply(r)
-	m.Compress = false
-
-	switch r.Opcode {
-	case dns.OpcodeQuery:
-		for _, q := range r.Question {
-			switch q.Qtype {
-			case dns.TypeA:
-				log.Printf("Query for %s\n", q.Name)
-				// Example: Always resolve to 1.2.3.4
-				rr, err := dns.NewRR(fmt.Sprintf("%s A 1.2.3.4", q.Name))
-				if err == nil {
-					m.Answer = append(m.Answer, rr)
-				}
-			}
+// DefaultEDNSUDPSize is the UDP payload size this server advertises back to
+// a client that negotiated EDNS(0), mirroring the sec-side sender's default.
+const DefaultEDNSUDPSize = 4096
+
+// DefaultUpstream is used when UPSTREAM_DNS_SERVERS isn't set.
+const DefaultUpstream = "udp://8.8.8.8:53"
+
+// upstreamTimeout and upstreamRetries bound how long and how often the
+// resolver will wait on a single upstream before failing over.
+const (
+	upstreamTimeout = 5 * time.Second
+	upstreamRetries = 1
+)
+
+// parseUpstreams turns a comma-separated UPSTREAM_DNS_SERVERS spec into
+// Upstream values. Each entry is "scheme://host:port", where scheme is udp,
+// tcp, or tls (DoT); tls entries may carry "#servername" for certificate
+// verification against a name other than the host.
+func parseUpstreams(spec string) []Upstream {
+	var upstreams []Upstream
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		scheme, rest, ok := strings.Cut(entry, "://")
+		if !ok {
+			scheme, rest = "udp", entry
 		}
-	}
 
-	err := w.WriteMsg(m)
-	if err != nil {
-		log.Println(err)
+		switch scheme {
+		case "udp":
+			upstreams = append(upstreams, NewUDPUpstream(rest))
+		case "tcp":
+			upstreams = append(upstreams, NewTCPUpstream(rest))
+		case "tls":
+			addr, serverName, _ := strings.Cut(rest, "#")
+			if serverName == "" {
+				serverName, _, _ = strings.Cut(addr, ":")
+			}
+			upstreams = append(upstreams, NewDoTUpstream(addr, serverName))
+		default:
+			log.Printf("Unknown upstream scheme %q in %q, skipping\n", scheme, entry)
+		}
 	}
+	return upstreams
 }
 
+// startDNSServer listens on both UDP and TCP so large EDNS(0) responses -
+// or ones a UDP client retries after a truncated reply - are served by the
+// same handler either way. It blocks until either listener fails.
 func startDNSServer(handleFunc func(dns.ResponseWriter, *dns.Msg)) (err error) {
 	// Attach request handler func
 	dns.HandleFunc(".", handleFunc)
 
-	// Listen on UDP
-	server := &dns.Server{Addr: LISTEN_ADDRESS, Net: "udp"}
-	log.Printf("Starting DNS server on %s\n", LISTEN_ADDRESS)
-	err = server.ListenAndServe()
-	if err != nil {
+	udpServer := &dns.Server{Addr: LISTEN_ADDRESS, Net: "udp"}
+	tcpServer := &dns.Server{Addr: LISTEN_ADDRESS, Net: "tcp"}
+
+	errCh := make(chan error, 2)
+	go func() {
+		log.Printf("Starting DNS server on %s (udp)\n", LISTEN_ADDRESS)
+		errCh <- udpServer.ListenAndServe()
+	}()
+	go func() {
+		log.Printf("Starting DNS server on %s (tcp)\n", LISTEN_ADDRESS)
+		errCh <- tcpServer.ListenAndServe()
+	}()
+
+	if err := <-errCh; err != nil {
 		return fmt.Errorf("failed to start server: %s", err.Error())
 	}
 	return nil
 }
 
 func main() {
-	if err := startDNSServer(handleTXTDNSRequest); err != nil {
+	upstreamSpec := os.Getenv("UPSTREAM_DNS_SERVERS")
+	if upstreamSpec == "" {
+		upstreamSpec = DefaultUpstream
+	}
+	upstreams := parseUpstreams(upstreamSpec)
+	log.Printf("Forwarding unclaimed queries to: %s\n", upstreamSpec)
+
+	zone := defaultZone()
+	if zonePath := os.Getenv("ZONE_FILE_PATH"); zonePath != "" {
+		loaded, err := LoadZoneFile(zonePath, BASE_DOMAIN)
+		if err != nil {
+			log.Printf("Error loading zone file %s, falling back to default zone: %v\n", zonePath, err)
+		} else {
+			zone = loaded
+		}
+	}
+
+	// typeExtractor claims every question it sees - the DNS-type-bit channel
+	// has no marker to distinguish its queries from real traffic - so it would
+	// swallow every query a normal client sends and the resolver would never
+	// forward anything upstream. Only register it when that channel is the
+	// one actually being exercised.
+	extractors := []Extractor{txtExtractor{}}
+	if os.Getenv("ENABLE_TYPED_COVERT_CHANNEL") != "" {
+		extractors = append(extractors, typeExtractor{})
+	}
+
+	resolver := NewResolver(upstreams, extractors, NewResponseBuilder(zone), upstreamTimeout, upstreamRetries)
+
+	if err := startDNSServer(resolver.ServeDNS); err != nil {
 		fmt.Fprintf(os.Stderr, "error: %s\n", err)
 		os.Exit(1)
 	}
@@ -0,0 +1,343 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// Extractor inspects a single question and, if it recognizes a covert
+// channel's wire format, consumes it and reports true. A question no
+// extractor claims is real traffic and gets forwarded upstream like any
+// other resolver would. opt is the request's EDNS(0) OPT pseudo-RR, if any,
+// since some channels carry their framing there instead of in the question.
+type Extractor interface {
+	Extract(q dns.Question, opt *dns.OPT) bool
+}
+
+// Upstream is a real DNS server the resolver can forward unclaimed queries
+// to - plain UDP/53, DNS-over-TCP, or DoT.
+type Upstream interface {
+	Name() string
+	Exchange(m *dns.Msg, timeout time.Duration) (*dns.Msg, error)
+}
+
+// udpUpstream forwards over plain UDP/53.
+type udpUpstream struct {
+	Addr string
+}
+
+func NewUDPUpstream(addr string) Upstream { return &udpUpstream{Addr: addr} }
+func (u *udpUpstream) Name() string       { return "udp://" + u.Addr }
+func (u *udpUpstream) Exchange(m *dns.Msg, timeout time.Duration) (*dns.Msg, error) {
+	c := &dns.Client{Net: "udp", Timeout: timeout}
+	resp, _, err := c.Exchange(m, u.Addr)
+	return resp, err
+}
+
+// tcpUpstream forwards over DNS-over-TCP.
+type tcpUpstream struct {
+	Addr string
+}
+
+func NewTCPUpstream(addr string) Upstream { return &tcpUpstream{Addr: addr} }
+func (u *tcpUpstream) Name() string       { return "tcp://" + u.Addr }
+func (u *tcpUpstream) Exchange(m *dns.Msg, timeout time.Duration) (*dns.Msg, error) {
+	c := &dns.Client{Net: "tcp", Timeout: timeout}
+	resp, _, err := c.Exchange(m, u.Addr)
+	return resp, err
+}
+
+// dotUpstream forwards over DNS-over-TLS (RFC 7858), typically port 853.
+type dotUpstream struct {
+	Addr       string
+	ServerName string
+}
+
+func NewDoTUpstream(addr, serverName string) Upstream {
+	return &dotUpstream{Addr: addr, ServerName: serverName}
+}
+func (u *dotUpstream) Name() string { return "tls://" + u.Addr }
+func (u *dotUpstream) Exchange(m *dns.Msg, timeout time.Duration) (*dns.Msg, error) {
+	c := &dns.Client{
+		Net:       "tcp-tls",
+		Timeout:   timeout,
+		TLSConfig: &tls.Config{ServerName: u.ServerName},
+	}
+	resp, _, err := c.Exchange(m, u.Addr)
+	return resp, err
+}
+
+// maxConsecutiveFailures is how many failed forwards in a row (each one
+// counted after all of its retries) mark an upstream unhealthy, so it's
+// skipped until one succeeds again.
+const maxConsecutiveFailures = 3
+
+// cacheKey identifies a cached answer by the tuple the DNS protocol itself
+// uses to distinguish queries.
+type cacheKey struct {
+	Name   string
+	Qtype  uint16
+	Qclass uint16
+}
+
+// cacheEntry is a cached response along with when it stops being valid,
+// derived from the minimum TTL across its records.
+type cacheEntry struct {
+	msg     *dns.Msg
+	expires time.Time
+}
+
+// TTLCache is a small in-memory response cache keyed on (qname, qtype,
+// qclass), honoring the minimum TTL of the cached response.
+type TTLCache struct {
+	mu      sync.Mutex
+	entries map[cacheKey]cacheEntry
+}
+
+func NewTTLCache() *TTLCache {
+	return &TTLCache{entries: make(map[cacheKey]cacheEntry)}
+}
+
+func cacheKeyFor(q dns.Question) cacheKey {
+	return cacheKey{Name: q.Name, Qtype: q.Qtype, Qclass: q.Qclass}
+}
+
+// Get returns a cached response for q, if one exists and hasn't expired.
+func (c *TTLCache) Get(q dns.Question) (*dns.Msg, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[cacheKeyFor(q)]
+	if !ok {
+		return nil, false
+	}
+	if time.Now().After(entry.expires) {
+		delete(c.entries, cacheKeyFor(q))
+		return nil, false
+	}
+	return entry.msg.Copy(), true
+}
+
+// Set stores resp for q, using the minimum TTL across its Answer, Ns, and
+// Extra sections. A response with no records or a zero minimum TTL isn't cached.
+func (c *TTLCache) Set(q dns.Question, resp *dns.Msg) {
+	minTTL := minTTLOf(resp)
+	if minTTL == 0 {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries[cacheKeyFor(q)] = cacheEntry{
+		msg:     resp.Copy(),
+		expires: time.Now().Add(time.Duration(minTTL) * time.Second),
+	}
+}
+
+func minTTLOf(m *dns.Msg) uint32 {
+	var min uint32
+	seen := false
+	for _, section := range [][]dns.RR{m.Answer, m.Ns, m.Extra} {
+		for _, rr := range section {
+			ttl := rr.Header().Ttl
+			if !seen || ttl < min {
+				min = ttl
+				seen = true
+			}
+		}
+	}
+	return min
+}
+
+// Resolver runs registered extractors over every incoming query and, for
+// whatever a question an extractor doesn't claim, forwards it to a real
+// upstream, with failover, retries, and a TTL-respecting cache.
+type Resolver struct {
+	Upstreams  []Upstream
+	Extractors []Extractor
+	Cache      *TTLCache
+	Responder  *ResponseBuilder
+	Timeout    time.Duration
+	Retries    int
+
+	mu       sync.Mutex
+	failures map[Upstream]int
+}
+
+func NewResolver(upstreams []Upstream, extractors []Extractor, responder *ResponseBuilder, timeout time.Duration, retries int) *Resolver {
+	return &Resolver{
+		Upstreams:  upstreams,
+		Extractors: extractors,
+		Cache:      NewTTLCache(),
+		Responder:  responder,
+		Timeout:    timeout,
+		Retries:    retries,
+		failures:   make(map[Upstream]int),
+	}
+}
+
+func (res *Resolver) markSuccess(u Upstream) {
+	res.mu.Lock()
+	defer res.mu.Unlock()
+	res.failures[u] = 0
+}
+
+func (res *Resolver) markFailure(u Upstream) {
+	res.mu.Lock()
+	defer res.mu.Unlock()
+	res.failures[u]++
+}
+
+// healthyUpstreams returns the upstreams under the consecutive-failure
+// threshold, in configured order. If every upstream is unhealthy, it returns
+// them all anyway rather than giving up on forwarding entirely.
+func (res *Resolver) healthyUpstreams() []Upstream {
+	res.mu.Lock()
+	var healthy []Upstream
+	for _, u := range res.Upstreams {
+		if res.failures[u] < maxConsecutiveFailures {
+			healthy = append(healthy, u)
+		}
+	}
+	res.mu.Unlock()
+
+	if len(healthy) == 0 {
+		return res.Upstreams
+	}
+	return healthy
+}
+
+// forward tries each healthy upstream in turn, retrying a failing one up to
+// Retries times before recording a failure against it and moving to the
+// next; maxConsecutiveFailures such failures in a row mark it unhealthy.
+func (res *Resolver) forward(m *dns.Msg) (*dns.Msg, error) {
+	var lastErr error
+	for _, u := range res.healthyUpstreams() {
+		for attempt := 0; attempt <= res.Retries; attempt++ {
+			resp, err := u.Exchange(m, res.Timeout)
+			if err == nil {
+				res.markSuccess(u)
+				return resp, nil
+			}
+			lastErr = err
+			fmt.Printf("Upstream %s exchange failed (attempt %d/%d): %v\n", u.Name(), attempt+1, res.Retries+1, err)
+		}
+		res.markFailure(u)
+	}
+	return nil, fmt.Errorf("all upstreams failed: %w", lastErr)
+}
+
+// ServeDNS implements the dns.Handler-shaped signature expected by
+// dns.HandleFunc: run extractors over every question except generated
+// normal-traffic ones (which are skipped and never forwarded), then forward
+// whatever remains unclaimed upstream.
+func (res *Resolver) ServeDNS(w dns.ResponseWriter, r *dns.Msg) {
+	m := new(dns.Msg)
+	m.SetReply(r)
+	m.Compress = false
+
+	if r.Opcode != dns.OpcodeQuery {
+		w.WriteMsg(m)
+		return
+	}
+
+	reqOpt := r.IsEdns0()
+
+	var unclaimed []dns.Question
+	claimedAny := false
+	for _, q := range r.Question {
+		fmt.Printf("Received query: Name=[%s], Type=[%s]\n", q.Name, dns.TypeToString[q.Qtype])
+
+		if isNormalTrafficQuery(q.Name) {
+			fmt.Printf("Ignoring normal traffic query to: %s\n", q.Name)
+			continue
+		}
+
+		markCovertStartTime()
+
+		claimed := false
+		for _, ex := range res.Extractors {
+			if ex.Extract(q, reqOpt) {
+				claimed = true
+				break
+			}
+		}
+		if claimed {
+			claimedAny = true
+		} else {
+			unclaimed = append(unclaimed, q)
+		}
+	}
+
+	if len(unclaimed) == 0 {
+		if claimedAny && res.Responder != nil {
+			// Give the covert-channel sender a plausible-looking reply
+			// instead of an empty message, which is itself a fingerprint.
+			res.writeRespectingSize(w, r, res.Responder.Build(r))
+			return
+		}
+		w.WriteMsg(m) // Nothing but ignored normal traffic
+		return
+	}
+
+	if len(unclaimed) == 1 {
+		if cached, ok := res.Cache.Get(unclaimed[0]); ok {
+			res.writeRespectingSize(w, r, cached)
+			return
+		}
+	}
+
+	fwd := new(dns.Msg)
+	fwd.Id = r.Id
+	fwd.RecursionDesired = r.RecursionDesired
+	fwd.Question = unclaimed
+	if opt := r.IsEdns0(); opt != nil {
+		fwd.SetEdns0(opt.UDPSize(), opt.Do())
+	}
+
+	resp, err := res.forward(fwd)
+	if err != nil {
+		fmt.Printf("Error forwarding query upstream: %v\n", err)
+		m.Rcode = dns.RcodeServerFailure
+		w.WriteMsg(m)
+		return
+	}
+
+	if len(unclaimed) == 1 {
+		res.Cache.Set(unclaimed[0], resp)
+	}
+
+	res.writeRespectingSize(w, r, resp)
+}
+
+// writeRespectingSize honors the client's EDNS(0) UDP payload size: a UDP
+// response that would exceed it gets its answer sections dropped and TC set,
+// same as a real resolver forcing a retry over TCP. TCP responses have no
+// such limit.
+func (res *Resolver) writeRespectingSize(w dns.ResponseWriter, r, resp *dns.Msg) {
+	resp.Id = r.Id
+
+	if _, isTCP := w.RemoteAddr().(*net.TCPAddr); isTCP {
+		w.WriteMsg(resp)
+		return
+	}
+
+	maxSize := dns.MinMsgSize
+	if opt := r.IsEdns0(); opt != nil {
+		maxSize = int(opt.UDPSize())
+	}
+
+	if resp.Len() > maxSize {
+		resp.Truncated = true
+		resp.Answer = nil
+		resp.Ns = nil
+		resp.Extra = nil
+	}
+
+	w.WriteMsg(resp)
+}
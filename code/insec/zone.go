@@ -0,0 +1,173 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// ZoneFile holds the records a ResponseBuilder answers from, keyed by
+// lowercased owner name. It can be loaded from a real zone file (RFC 1035
+// presentation format, as parsed by github.com/miekg/dns) or built in memory
+// as a fallback when no zone is configured.
+type ZoneFile struct {
+	Apex    string
+	Records map[string][]dns.RR
+}
+
+// LoadZoneFile parses a zone file at path using the miekg/dns RR text format.
+func LoadZoneFile(path, apex string) (*ZoneFile, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("opening zone file: %w", err)
+	}
+	defer f.Close()
+
+	zone := &ZoneFile{Apex: strings.ToLower(dns.Fqdn(apex)), Records: make(map[string][]dns.RR)}
+
+	parser := dns.NewZoneParser(f, "", path)
+	for rr, ok := parser.Next(); ok; rr, ok = parser.Next() {
+		name := strings.ToLower(rr.Header().Name)
+		zone.Records[name] = append(zone.Records[name], rr)
+	}
+	if err := parser.Err(); err != nil {
+		return nil, fmt.Errorf("parsing zone file: %w", err)
+	}
+
+	return zone, nil
+}
+
+// defaultZone builds a small in-memory zone covering BASE_DOMAIN, used when
+// no real zone file is configured, so covert-channel replies still look like
+// a plausible answer instead of a hard-coded "1.2.3.4".
+func defaultZone() *ZoneFile {
+	apex := dns.Fqdn(BASE_DOMAIN)
+	records := []string{
+		fmt.Sprintf("%s 300 IN SOA ns1.%s admin.%s 1 3600 600 86400 300", apex, apex, apex),
+		fmt.Sprintf("%s 300 IN NS ns1.%s", apex, apex),
+		fmt.Sprintf("%s 300 IN NS ns2.%s", apex, apex),
+		fmt.Sprintf("ns1.%s 300 IN A 1.2.3.4", apex),
+		fmt.Sprintf("ns2.%s 300 IN A 1.2.3.5", apex),
+		fmt.Sprintf("%s 300 IN A 1.2.3.4", apex),
+		fmt.Sprintf("%s 300 IN AAAA ::1", apex),
+		fmt.Sprintf("_covert._tcp.%s 300 IN SRV 0 0 53 ns1.%s", apex, apex),
+		fmt.Sprintf("covert._covert._tcp.%s 300 IN PTR _covert._tcp.%s", apex, apex),
+		fmt.Sprintf("_covert._tcp.%s 300 IN TXT \"middlebox covert channel\"", apex),
+	}
+
+	zone := &ZoneFile{Apex: strings.ToLower(apex), Records: make(map[string][]dns.RR)}
+	for _, line := range records {
+		rr, err := dns.NewRR(line)
+		if err != nil {
+			// A bad hard-coded zone line is a bug in this file, not runtime
+			// input, so fail loudly rather than serving a half-built zone.
+			panic(fmt.Sprintf("defaultZone: %v", err))
+		}
+		name := strings.ToLower(rr.Header().Name)
+		zone.Records[name] = append(zone.Records[name], rr)
+	}
+	return zone
+}
+
+func (z *ZoneFile) lookup(name string, rtype uint16) []dns.RR {
+	var out []dns.RR
+	for _, rr := range z.Records[strings.ToLower(name)] {
+		if rr.Header().Rrtype == rtype {
+			out = append(out, rr)
+		}
+	}
+	return out
+}
+
+func (z *ZoneFile) soa() dns.RR {
+	for _, rr := range z.lookup(z.Apex, dns.TypeSOA) {
+		return rr
+	}
+	return nil
+}
+
+// ResponseBuilder composes RFC-shaped replies for covert-channel queries, so
+// they carry the RR types a real zone would return instead of an empty
+// message, which is itself a fingerprint.
+type ResponseBuilder struct {
+	Zone *ZoneFile
+}
+
+func NewResponseBuilder(zone *ZoneFile) *ResponseBuilder {
+	return &ResponseBuilder{Zone: zone}
+}
+
+// Build composes a reply covering every question in r.
+func (rb *ResponseBuilder) Build(r *dns.Msg) *dns.Msg {
+	m := new(dns.Msg)
+	m.SetReply(r)
+	m.Compress = false
+	m.Authoritative = true
+
+	if opt := r.IsEdns0(); opt != nil {
+		m.SetEdns0(DefaultEDNSUDPSize, opt.Do())
+	}
+
+	for _, q := range r.Question {
+		rb.answer(m, q)
+	}
+	return m
+}
+
+func (rb *ResponseBuilder) answer(m *dns.Msg, q dns.Question) {
+	answers := rb.Zone.lookup(q.Name, q.Qtype)
+	if len(answers) == 0 {
+		rb.stampNoData(m)
+		return
+	}
+	m.Answer = append(m.Answer, answers...)
+
+	switch q.Qtype {
+	case dns.TypeA, dns.TypeAAAA:
+		// Authority + glue, like a real authoritative answer.
+		for _, ns := range rb.Zone.lookup(rb.Zone.Apex, dns.TypeNS) {
+			m.Ns = append(m.Ns, ns)
+			if nsRR, ok := ns.(*dns.NS); ok {
+				m.Extra = append(m.Extra, rb.Zone.lookup(nsRR.Ns, dns.TypeA)...)
+				m.Extra = append(m.Extra, rb.Zone.lookup(nsRR.Ns, dns.TypeAAAA)...)
+			}
+		}
+	case dns.TypeSRV:
+		// SRV target's address records as glue, same as mDNS service replies.
+		for _, a := range answers {
+			if srv, ok := a.(*dns.SRV); ok {
+				m.Extra = append(m.Extra, rb.Zone.lookup(srv.Target, dns.TypeA)...)
+				m.Extra = append(m.Extra, rb.Zone.lookup(srv.Target, dns.TypeAAAA)...)
+			}
+		}
+	case dns.TypePTR:
+		// Pull in the matching TXT+SRV+address records, mirroring the mDNS
+		// service-instance composition pattern.
+		for _, p := range answers {
+			ptr, ok := p.(*dns.PTR)
+			if !ok {
+				continue
+			}
+			m.Extra = append(m.Extra, rb.Zone.lookup(ptr.Ptr, dns.TypeTXT)...)
+			for _, srvRR := range rb.Zone.lookup(ptr.Ptr, dns.TypeSRV) {
+				m.Extra = append(m.Extra, srvRR)
+				if srv, ok := srvRR.(*dns.SRV); ok {
+					m.Extra = append(m.Extra, rb.Zone.lookup(srv.Target, dns.TypeA)...)
+				}
+			}
+		}
+	}
+}
+
+// stampNoData marks the message NXDOMAIN/NODATA and attaches the zone's SOA,
+// as a real authoritative server would rather than returning an empty answer.
+func (rb *ResponseBuilder) stampNoData(m *dns.Msg) {
+	if len(rb.Zone.Records[strings.ToLower(m.Question[0].Name)]) == 0 {
+		m.Rcode = dns.RcodeNameError
+	}
+	if soa := rb.Zone.soa(); soa != nil {
+		m.Ns = append(m.Ns, soa)
+	}
+}
